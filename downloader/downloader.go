@@ -0,0 +1,365 @@
+// Package downloader performs multi-connection ranged HTTP downloads with
+// resume support. When the remote server advertises byte-range support and
+// the file is large enough to be worth splitting, the file is downloaded
+// concurrently in chunks and each chunk is written directly to its offset in
+// the destination file. Progress is checkpointed to a small sidecar file so
+// an interrupted download can resume by re-requesting only the unfinished
+// byte ranges.
+package downloader
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/edosssa/home-vision-project/storage"
+	"github.com/edosssa/home-vision-project/xfer"
+)
+
+const (
+	defaultConnectionsPerFile = 4
+	defaultMinChunkedSize     = 1 << 20 // 1MB
+)
+
+// Options configures a Download call.
+type Options struct {
+	// ConnectionsPerFile is the number of concurrent range requests to split
+	// a downloadable file into. Defaults to 4.
+	ConnectionsPerFile int
+
+	// MinChunkedSize is the smallest Content-Length, in bytes, for which
+	// chunked downloading is attempted. Files smaller than this (or files
+	// served by a host that doesn't advertise range support) are fetched in
+	// a single stream. Defaults to 1MB.
+	MinChunkedSize int64
+
+	// Client is the http.Client used for all requests. Defaults to
+	// http.DefaultClient.
+	Client *http.Client
+
+	// OnProgress, if set, is called with the number of bytes just written,
+	// possibly concurrently from multiple chunk workers. It's intended to
+	// feed a ui.FileProgress's Add method.
+	OnProgress func(n int64)
+
+	// Preflight, if set, is an already-open GET response body for url
+	// (typically returned by mediatype.Detect while sniffing the content
+	// type). When the download ends up using the single-stream path, this
+	// is read instead of issuing a second GET. It's closed once consumed.
+	// The ranged path closes it unread, since that issues its own per-chunk
+	// GETs regardless.
+	Preflight io.ReadCloser
+}
+
+func (o Options) withDefaults() Options {
+	if o.ConnectionsPerFile <= 0 {
+		o.ConnectionsPerFile = defaultConnectionsPerFile
+	}
+	if o.MinChunkedSize <= 0 {
+		o.MinChunkedSize = defaultMinChunkedSize
+	}
+	if o.Client == nil {
+		o.Client = http.DefaultClient
+	}
+	return o
+}
+
+// chunk describes a single byte range of the download and how much of it has
+// been written so far.
+type chunk struct {
+	Start   int64 `json:"start"`
+	End     int64 `json:"end"` // inclusive
+	Written int64 `json:"written"`
+}
+
+func (c chunk) done() bool {
+	return c.Written >= c.End-c.Start+1
+}
+
+// partMeta is the sidecar file persisted next to the destination file so a
+// download can be resumed.
+type partMeta struct {
+	URL    string  `json:"url"`
+	Size   int64   `json:"size"`
+	Chunks []chunk `json:"chunks"`
+}
+
+// Download fetches url and writes it to name in sink, splitting the
+// transfer across Options.ConnectionsPerFile workers when sink supports
+// random-access writes, the server supports ranged requests, and the file
+// is at least Options.MinChunkedSize. Sinks without random access (tar, S3)
+// always use a single stream. If a previous, interrupted run of the same
+// URL and size left a resume sidecar behind, only the unfinished byte
+// ranges are re-requested.
+func Download(ctx context.Context, url string, sink storage.Sink, name string, opts Options) error {
+	opts = opts.withDefaults()
+
+	size, acceptsRanges, err := probe(ctx, opts.Client, url)
+	if err != nil {
+		if opts.Preflight != nil {
+			opts.Preflight.Close()
+		}
+		return fmt.Errorf("Error probing %s: %s", url, err)
+	}
+
+	ra, randomAccess := sink.(storage.RandomAccessSink)
+	if !randomAccess || !acceptsRanges || size < opts.MinChunkedSize {
+		return downloadSingleStream(ctx, opts.Client, url, sink, name, size, opts.OnProgress, opts.Preflight)
+	}
+
+	if opts.Preflight != nil {
+		opts.Preflight.Close()
+	}
+	return downloadRanged(ctx, opts, url, ra, name, size)
+}
+
+func downloadRanged(ctx context.Context, opts Options, url string, ra storage.RandomAccessSink, name string, size int64) error {
+	dest := ra.Path(name)
+
+	meta, err := loadOrCreateMeta(ra, dest, url, size, opts.ConnectionsPerFile)
+	if err != nil {
+		return err
+	}
+
+	w, err := ra.OpenAt(name, size)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	// metaMu guards every chunk's Written field and the sidecar file itself:
+	// saveMeta marshals all of meta.Chunks, so a checkpoint from one chunk's
+	// goroutine would otherwise race with another chunk's goroutine updating
+	// its own Written field concurrently.
+	var metaMu sync.Mutex
+
+	errs := make(chan error, len(meta.Chunks))
+	pending := 0
+
+	for i := range meta.Chunks {
+		c := &meta.Chunks[i]
+		if c.done() {
+			continue
+		}
+		pending++
+		go func(c *chunk) {
+			errs <- downloadChunk(ctx, opts.Client, url, w, c, opts.OnProgress, &metaMu, func() error {
+				return saveMeta(ra, dest, meta)
+			})
+		}(c)
+	}
+
+	var firstErr error
+	for i := 0; i < pending; i++ {
+		if err := <-errs; err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	if firstErr != nil {
+		return firstErr
+	}
+
+	return ra.RemoveFile(partPath(dest))
+}
+
+// probe issues a HEAD request to determine the size of the resource and
+// whether the server honours Range requests.
+func probe(ctx context.Context, client *http.Client, url string) (size int64, acceptsRanges bool, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
+	if err != nil {
+		return 0, false, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, false, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, false, &xfer.HTTPStatusError{StatusCode: resp.StatusCode}
+	}
+
+	size = resp.ContentLength
+	acceptsRanges = strings.EqualFold(resp.Header.Get("Accept-Ranges"), "bytes")
+	return size, acceptsRanges, nil
+}
+
+// downloadSingleStream fetches the whole resource in one GET, for sinks or
+// servers that can't support the ranged, multi-connection path. If
+// preflight is non-nil, its bytes are used instead of issuing a new GET.
+func downloadSingleStream(ctx context.Context, client *http.Client, url string, sink storage.Sink, name string, size int64, onProgress func(int64), preflight io.ReadCloser) error {
+	body := preflight
+	if body == nil {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return &xfer.HTTPStatusError{StatusCode: resp.StatusCode}
+		}
+		body = resp.Body
+	}
+	defer body.Close()
+
+	w, err := sink.Create(name, size)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(w, newProgressReader(body, onProgress))
+	// Some sinks (e.g. S3) only know whether the write actually succeeded
+	// once Close returns, so that error must not be discarded.
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// downloadChunk requests the remaining bytes of c and writes them to w at
+// their absolute offset. checkpoint is called after each successful read so
+// progress survives a crash mid-chunk. mu must be held around both the
+// update to c.Written and the checkpoint call, since checkpoint serializes
+// every chunk's Written field and mustn't observe a torn write from a
+// sibling chunk's goroutine.
+func downloadChunk(ctx context.Context, client *http.Client, url string, w storage.WriterAtCloser, c *chunk, onProgress func(int64), mu *sync.Mutex, checkpoint func() error) error {
+	start := c.Start + c.Written
+	if start > c.End {
+		return nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, c.End))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusPartialContent {
+		return fmt.Errorf("Error on ranged GET %s: expected 206, got %d", url, resp.StatusCode)
+	}
+
+	buf := make([]byte, 32*1024)
+	offset := start
+	for {
+		n, readErr := resp.Body.Read(buf)
+		if n > 0 {
+			if _, writeErr := w.WriteAt(buf[:n], offset); writeErr != nil {
+				return writeErr
+			}
+			offset += int64(n)
+
+			mu.Lock()
+			c.Written += int64(n)
+			err := checkpoint()
+			mu.Unlock()
+
+			if onProgress != nil {
+				onProgress(int64(n))
+			}
+			if err != nil {
+				return err
+			}
+		}
+		if readErr == io.EOF {
+			return nil
+		}
+		if readErr != nil {
+			return readErr
+		}
+	}
+}
+
+// progressReader wraps an io.Reader and reports each Read's byte count to
+// onProgress, the way io.TeeReader copies bytes elsewhere as they're read.
+type progressReader struct {
+	r          io.Reader
+	onProgress func(int64)
+}
+
+func newProgressReader(r io.Reader, onProgress func(int64)) io.Reader {
+	if onProgress == nil {
+		return r
+	}
+	return &progressReader{r: r, onProgress: onProgress}
+}
+
+func (p *progressReader) Read(buf []byte) (int, error) {
+	n, err := p.r.Read(buf)
+	if n > 0 {
+		p.onProgress(int64(n))
+	}
+	return n, err
+}
+
+func partPath(dest string) string {
+	return dest + storage.ResumeSidecarSuffix
+}
+
+// loadOrCreateMeta loads a sidecar file left over from an interrupted
+// download of the same URL and size, or builds a fresh chunk plan.
+func loadOrCreateMeta(ra storage.RandomAccessSink, dest, url string, size int64, connections int) (*partMeta, error) {
+	if existing, err := readMeta(ra, dest); err == nil && existing.URL == url && existing.Size == size {
+		return existing, nil
+	}
+
+	return &partMeta{URL: url, Size: size, Chunks: planChunks(size, connections)}, nil
+}
+
+func readMeta(ra storage.RandomAccessSink, dest string) (*partMeta, error) {
+	b, err := ra.ReadFile(partPath(dest))
+	if err != nil {
+		return nil, err
+	}
+	var m partMeta
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+func saveMeta(ra storage.RandomAccessSink, dest string, m *partMeta) error {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return ra.WriteFile(partPath(dest), b)
+}
+
+// planChunks splits [0, size) into up to `connections` roughly equal,
+// inclusive byte ranges.
+func planChunks(size int64, connections int) []chunk {
+	if connections < 1 {
+		connections = 1
+	}
+	chunkSize := size / int64(connections)
+	if chunkSize == 0 {
+		connections = 1
+		chunkSize = size
+	}
+
+	chunks := make([]chunk, 0, connections)
+	start := int64(0)
+	for i := 0; i < connections; i++ {
+		end := start + chunkSize - 1
+		if i == connections-1 {
+			end = size - 1
+		}
+		chunks = append(chunks, chunk{Start: start, End: end})
+		start = end + 1
+	}
+	return chunks
+}
@@ -0,0 +1,149 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/edosssa/home-vision-project/storage"
+)
+
+func TestDownload_Ranged(t *testing.T) {
+	content := bytes.Repeat([]byte("abcdefgh"), 4096) // 32KB
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "img", time.Time{}, bytes.NewReader(content))
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	sink, err := storage.NewFSSink(dir)
+	if err != nil {
+		t.Fatalf("NewFSSink: %s", err)
+	}
+
+	err = Download(context.Background(), srv.URL, sink, "img.bin", Options{
+		ConnectionsPerFile: 4,
+		MinChunkedSize:     1,
+	})
+	if err != nil {
+		t.Fatalf("Download: %s", err)
+	}
+
+	got, err := os.ReadFile(filepath.Join(dir, "img.bin"))
+	if err != nil {
+		t.Fatalf("ReadFile: %s", err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Fatalf("downloaded content mismatch: got %d bytes, want %d", len(got), len(content))
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "img.bin"+storage.ResumeSidecarSuffix)); !os.IsNotExist(err) {
+		t.Fatalf("expected resume sidecar to be removed after a completed download, stat err = %v", err)
+	}
+}
+
+// TestDownload_ResumesAfterTruncatedChunk simulates a connection drop partway
+// through the single chunk of a ranged download, then re-runs Download to
+// confirm it resumes from the partial progress recorded in the sidecar
+// instead of re-fetching bytes that were already written.
+func TestDownload_ResumesAfterTruncatedChunk(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 1000) // 10KB
+	var attempt int32
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Range") == "" {
+			http.ServeContent(w, r, "img", time.Time{}, bytes.NewReader(content))
+			return
+		}
+
+		if atomic.AddInt32(&attempt, 1) == 1 {
+			// Advertise the full remaining range but only write half of it,
+			// then return: the server notices the short write and aborts the
+			// connection, so the client sees an error partway through.
+			half := len(content) / 2
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes 0-%d/%d", len(content)-1, len(content)))
+			w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+			w.WriteHeader(http.StatusPartialContent)
+			w.Write(content[:half])
+			return
+		}
+
+		http.ServeContent(w, r, "img", time.Time{}, bytes.NewReader(content))
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	sink, err := storage.NewFSSink(dir)
+	if err != nil {
+		t.Fatalf("NewFSSink: %s", err)
+	}
+
+	opts := Options{ConnectionsPerFile: 1, MinChunkedSize: 1}
+
+	if err := Download(context.Background(), srv.URL, sink, "img.bin", opts); err == nil {
+		t.Fatal("expected first Download to fail on the truncated response")
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "img.bin"+storage.ResumeSidecarSuffix)); err != nil {
+		t.Fatalf("expected a resume sidecar after the truncated attempt: %s", err)
+	}
+
+	if err := Download(context.Background(), srv.URL, sink, "img.bin", opts); err != nil {
+		t.Fatalf("resumed Download: %s", err)
+	}
+
+	got, err := os.ReadFile(filepath.Join(dir, "img.bin"))
+	if err != nil {
+		t.Fatalf("ReadFile: %s", err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Fatal("resumed download content mismatch")
+	}
+}
+
+func TestDownload_FallsBackToSingleStreamWithoutRangeSupport(t *testing.T) {
+	content := bytes.Repeat([]byte("z"), 8192)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// No Accept-Ranges header, so probe() reports the server as
+		// non-range-capable regardless of what the client asks for.
+		w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+		if r.Method == http.MethodHead {
+			return
+		}
+		w.Write(content)
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	sink, err := storage.NewFSSink(dir)
+	if err != nil {
+		t.Fatalf("NewFSSink: %s", err)
+	}
+
+	err = Download(context.Background(), srv.URL, sink, "img.bin", Options{MinChunkedSize: 1})
+	if err != nil {
+		t.Fatalf("Download: %s", err)
+	}
+
+	got, err := os.ReadFile(filepath.Join(dir, "img.bin"))
+	if err != nil {
+		t.Fatalf("ReadFile: %s", err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Fatal("single-stream download content mismatch")
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "img.bin"+storage.ResumeSidecarSuffix)); !os.IsNotExist(err) {
+		t.Fatal("single-stream download shouldn't leave a resume sidecar behind")
+	}
+}
@@ -1,6 +1,9 @@
 package main
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"flag"
 	"fmt"
@@ -9,11 +12,13 @@ import (
 	"net/http"
 	"net/url"
 	"os"
-	"path"
-	"strings"
 	"sync"
-	"sync/atomic"
 
+	"github.com/edosssa/home-vision-project/downloader"
+	"github.com/edosssa/home-vision-project/mediatype"
+	"github.com/edosssa/home-vision-project/storage"
+	"github.com/edosssa/home-vision-project/ui"
+	"github.com/edosssa/home-vision-project/xfer"
 	"github.com/pterm/pterm"
 )
 
@@ -22,8 +27,12 @@ const (
 )
 
 var (
-	saveDir   = "./out"
-	pageCount = 10
+	output             = "fs://./out"
+	pageCount          = 10
+	noProgress         = false
+	skipExisting       = false
+	connectionsPerFile = 0
+	minChunkedSize     int64
 )
 
 type house struct {
@@ -39,39 +48,44 @@ type getHomesResponse struct {
 	Ok     bool    `json:"ok"`
 }
 
-type downloadStatus struct {
-	page    int
-	total   int
-	current int
-}
-
 func main() {
 	flag.IntVar(&pageCount, "pageCount", pageCount, "The number of pages to download")
-	flag.StringVar(&saveDir, "downloadPath", saveDir, "The directory to download the images to")
+	flag.StringVar(&output, "output", output, "Where to write downloaded images: fs://dir, tar://archive.tar, or s3://bucket/prefix")
+	flag.BoolVar(&noProgress, "no-progress", noProgress, "Print one line per completed download instead of drawing progress bars")
+	flag.BoolVar(&skipExisting, "skip-existing", skipExisting, "Skip files that have already been downloaded")
+	flag.IntVar(&connectionsPerFile, "connectionsPerFile", connectionsPerFile, "Concurrent range requests per file; 0 uses the downloader's default (4)")
+	flag.Int64Var(&minChunkedSize, "minChunkedSize", minChunkedSize, "Minimum file size in bytes before chunked, ranged downloading is used; 0 uses the downloader's default (1MB)")
 
 	flag.Parse()
 
-	// Create the download directory if it doesn't exist
-	if _, err := os.Stat(saveDir); os.IsNotExist(err) {
-		os.Mkdir(saveDir, 0755)
+	sink, err := storage.Open(output)
+	if err != nil {
+		pterm.Fatal.Printfln("Error opening output %q: %s", output, err)
 	}
 
-	// I'm assuming that each page has exactly 10 houses, so the total number of downloads would be 10 * pageCount
-	progressBar, _ := pterm.DefaultProgressbar.WithTotal(pageCount * 10).WithTitle("Downloading images...").Start()
-	defer func() {
-		progressBar.Stop()
-		pterm.Success.Printfln("Downloaded %d images", progressBar.Total)
-	}()
+	if skipExisting {
+		if incompatible, ok := sink.(storage.SkipExistingIncompatible); ok {
+			pterm.Fatal.Printfln("Error: %s", incompatible.SkipExistingIncompatibleReason())
+		}
+	}
 
-	var pm sync.Mutex
+	scratchDir, err := ioutil.TempDir("", "home-vision-photos-")
+	if err != nil {
+		pterm.Fatal.Printfln("Error creating scratch directory: %s", err)
+	}
+	defer os.RemoveAll(scratchDir)
 
-	logProgress := func(d downloadStatus) {
-		// Not entirely sure if the increment function on the progress bar is thread safe, so we'll use a mutex just to be safe
-		pm.Lock()
-		defer pm.Unlock()
-		progressBar.Increment()
+	cache, err := newPhotoCache(scratchDir)
+	if err != nil {
+		pterm.Fatal.Printfln("Error creating photo cache: %s", err)
 	}
 
+	reporter := ui.New(ui.Options{NoProgress: noProgress})
+	defer reporter.Stop()
+
+	ctx := context.Background()
+	mgr := xfer.NewTransferManager(xfer.Options{})
+
 	var wg sync.WaitGroup
 
 	for i := 1; i <= pageCount; i++ {
@@ -79,47 +93,82 @@ func main() {
 
 		// To avoid capturing the loop variable
 		pageNumber := i
-		go downloadImages(pageNumber, logProgress, &wg)
+		go downloadImages(ctx, mgr, reporter, sink, cache, pageNumber, &wg)
 	}
 
 	wg.Wait()
+
+	if c, ok := sink.(storage.Closer); ok {
+		if err := c.Close(); err != nil {
+			pterm.Error.Printfln("Error closing output %q: %s", output, err)
+		}
+	}
 }
 
 // downloadImages will download the images from the given page number
-func downloadImages(pageNumber int, notify func(downloadStatus), wg *sync.WaitGroup) {
+func downloadImages(ctx context.Context, mgr *xfer.TransferManager, reporter ui.ProgressReporter, sink storage.Sink, cache *photoCache, pageNumber int, wg *sync.WaitGroup) {
+	defer wg.Done()
+
 	var houses []house
 
-	// First fetch the houses
-	retryIndefintely(func() (err error) {
+	fetchKey := fmt.Sprintf("page-%d", pageNumber)
+	fetchWatcher := mgr.Fetch(ctx, fetchKey, func(ctx context.Context) (err error) {
 		houses, err = fetchHouses(pageNumber)
 		return
 	})
+	<-fetchWatcher.Done()
+	if err := fetchWatcher.Err(); err != nil {
+		pterm.Error.Printfln("Error fetching page %d: %s", pageNumber, err)
+		return
+	}
 
-	// Keeps track of the download count for this page
-	var downloadCount uint32
-	downloadChan := make(chan struct{}, len(houses))
+	page := reporter.Page(pageNumber, len(houses))
 
-	// Start a goroutine to download the image for each house
+	var downloadsWg sync.WaitGroup
 	for _, h := range houses {
 		house := h
-		go retryIndefintely(func() error {
-			ext, err := getFileExtension(house.PhotoURL)
-			if err != nil {
-				return fmt.Errorf("Error getting file extension: %s", err)
+		downloadsWg.Add(1)
+
+		go func() {
+			defer downloadsWg.Done()
+
+			file := page.File(house.PhotoURL, 0)
+
+			if skipExisting {
+				skip, err := cache.alreadyWritten(ctx, sink, house)
+				if err != nil {
+					pterm.Error.Printfln("Error checking existing output for %s: %s", house.PhotoURL, err)
+					file.Done(err)
+					return
+				}
+				if skip {
+					file.Done(nil)
+					return
+				}
 			}
-			fileName := fmt.Sprintf("%d-%s-%s.%s", house.ID, house.Homeowner, house.Address, ext)
-			filePath := path.Join(saveDir, fileName)
-			return downloadImage(house.PhotoURL, filePath, downloadChan)
-		})
-	}
 
-	for i := 0; i < len(houses); i++ {
-		<-downloadChan
-		atomic.AddUint32(&downloadCount, 1)
-		notify(downloadStatus{page: pageNumber, total: len(houses), current: int(downloadCount)})
+			// mgr.Download dedupes concurrent fetches of the same
+			// PhotoURL (which the API reuses across distinct houses), so
+			// only one of these closures actually hits the network; every
+			// house still writes its own output below via cache.writeTo,
+			// regardless of which one won the race.
+			fetchWatcher := mgr.Download(ctx, house.PhotoURL, func(ctx context.Context) error {
+				return cache.fetch(ctx, house.PhotoURL, file.Add)
+			})
+			<-fetchWatcher.Done()
+
+			err := fetchWatcher.Err()
+			if err == nil {
+				err = cache.writeTo(sink, house)
+			}
+			if err != nil {
+				pterm.Error.Printfln("Error downloading %s: %s", house.PhotoURL, err)
+			}
+			file.Done(err)
+		}()
 	}
 
-	wg.Done()
+	downloadsWg.Wait()
 }
 
 // fetchHouses will fetch the houses from the given page number
@@ -140,7 +189,7 @@ func fetchHouses(pageNumber int) ([]house, error) {
 	defer resp.Body.Close()
 
 	if resp.StatusCode != 200 {
-		return nil, fmt.Errorf("Error fetching page: %d", pageNumber)
+		return nil, &xfer.HTTPStatusError{StatusCode: resp.StatusCode}
 	}
 
 	body, _ := ioutil.ReadAll(resp.Body)
@@ -154,67 +203,131 @@ func fetchHouses(pageNumber int) ([]house, error) {
 	return r.Houses, nil
 }
 
-// downloadImage will download the image from the given url and save it to the given file name
-func downloadImage(url string, fileName string, c chan struct{}) error {
-	req, err := http.NewRequest("GET", url, nil)
+// cachedPhoto is a photoCache entry: the local scratch path a PhotoURL was
+// downloaded to, and its detected file extension.
+type cachedPhoto struct {
+	path string
+	ext  string
+}
+
+// photoCache fetches each distinct PhotoURL into a local scratch directory
+// at most once, then lets every house that references it copy its own
+// output from that single fetch. This matters because the home-vision API
+// reuses the same PhotoURL across distinct house records, and
+// xfer.TransferManager.Download only runs the xferFunc registered by
+// whichever house's call wins the dedup race; without this cache, every
+// other house sharing that URL would silently never get its file written.
+type photoCache struct {
+	sink storage.RandomAccessSink // backed by a scratch directory; one entry per PhotoURL
+
+	mu      sync.Mutex
+	entries map[string]cachedPhoto
+}
+
+// newPhotoCache builds a photoCache that stores fetched photos under dir.
+func newPhotoCache(dir string) (*photoCache, error) {
+	sink, err := storage.NewFSSink(dir)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return err
+	return &photoCache{sink: sink, entries: make(map[string]cachedPhoto)}, nil
+}
+
+// fetch downloads photoURL into the cache, unless a previous call already
+// did. It's meant to run as the xferFunc passed to mgr.Download so
+// concurrent fetches for the same URL still only hit the network once.
+func (c *photoCache) fetch(ctx context.Context, photoURL string, onProgress func(n int64)) error {
+	c.mu.Lock()
+	_, cached := c.entries[photoURL]
+	c.mu.Unlock()
+	if cached {
+		return nil
 	}
-	defer resp.Body.Close()
 
-	f, err := os.OpenFile(fileName, os.O_CREATE|os.O_WRONLY, 0644)
+	detected, err := mediatype.Detect(ctx, http.DefaultClient, photoURL)
 	if err != nil {
-		return err
+		return fmt.Errorf("Error detecting file type: %s", err)
 	}
 
-	defer f.Close()
-
-	_, err = io.Copy(f, resp.Body)
-	if err != nil {
+	name := cacheEntryName(photoURL)
+	if err := downloader.Download(ctx, photoURL, c.sink, name, downloader.Options{
+		ConnectionsPerFile: connectionsPerFile,
+		MinChunkedSize:     minChunkedSize,
+		OnProgress:         onProgress,
+		Preflight:          detected.Body,
+	}); err != nil {
 		return err
 	}
 
-	c <- struct{}{}
+	c.mu.Lock()
+	c.entries[photoURL] = cachedPhoto{path: c.sink.Path(name), ext: detected.Ext}
+	c.mu.Unlock()
 	return nil
 }
 
-// retryIndefintely will retry the given function until it succeeds.
-// In a more serious application, it's probably a bad idea to retry indefinitely without some sort of limit,
-// but for this usecase, it's fine.
-func retryIndefintely(f func() error) {
-	for {
-		err := f()
-		if err == nil {
-			return
-		}
+// writeTo copies h's cached photo into sink under a filename derived from
+// h's ID/Homeowner/Address. Unlike fetch, it runs once per house, including
+// houses whose fetch was deduped against another house's PhotoURL.
+func (c *photoCache) writeTo(sink storage.Sink, h house) error {
+	c.mu.Lock()
+	entry := c.entries[h.PhotoURL]
+	c.mu.Unlock()
+
+	fileName := outputFileName(h, entry.ext)
+
+	src, err := os.Open(entry.path)
+	if err != nil {
+		return err
 	}
-}
+	defer src.Close()
 
-// getFileExtension infers the file extension from a url by probing the content type
-func getFileExtension(url string) (string, error) {
-	// Instead of just assuming the extension is in the url which is a valid assumption for this use case,
-	// we'll instead extract the content type from the HEAD response and then infer the extension from that
-	req, err := http.NewRequest("HEAD", url, nil)
+	info, err := src.Stat()
 	if err != nil {
-		return "", err
+		return err
 	}
-	resp, err := http.DefaultClient.Do(req)
+
+	dst, err := sink.Create(fileName, info.Size())
 	if err != nil {
-		return "", err
+		return err
 	}
-	mimeHeader := resp.Header.Get("Content-Type")
-	mimeType := mimeHeader[strings.Index(mimeHeader, "/")+1:]
+	defer dst.Close()
+
+	_, err = io.Copy(dst, src)
+	return err
+}
 
-	switch mimeType {
-	case "jpeg":
-		return "jpg", nil
+// alreadyWritten reports whether h's output already exists in sink, so its
+// caller can skip submitting a fetch for h.PhotoURL entirely instead of
+// downloading it again just to throw the bytes away. It still calls
+// mediatype.Detect to learn the extension the output filename would use:
+// that is a HEAD request, plus a GET whose first bytes are sniffed when the
+// HEAD yields no recognized image type. The GET body is closed right after
+// sniffing, so unlike fetch it never downloads the whole photo.
+func (c *photoCache) alreadyWritten(ctx context.Context, sink storage.Sink, h house) (bool, error) {
+	detected, err := mediatype.Detect(ctx, http.DefaultClient, h.PhotoURL)
+	if err != nil {
+		return false, fmt.Errorf("Error detecting file type: %s", err)
+	}
+	if detected.Body != nil {
+		detected.Body.Close()
 	}
 
-	return mimeType, nil
+	return sink.Exists(outputFileName(h, detected.Ext))
+}
+
+// outputFileName derives h's output filename from its ID/Homeowner/Address
+// and the detected file extension.
+func outputFileName(h house, ext string) string {
+	homeowner := mediatype.SanitizeFilename(h.Homeowner)
+	address := mediatype.SanitizeFilename(h.Address)
+	return fmt.Sprintf("%d-%s-%s.%s", h.ID, homeowner, address, ext)
+}
+
+// cacheEntryName derives a filesystem-safe scratch filename for photoURL, so
+// distinct houses that reference the same URL land on the same cache entry.
+func cacheEntryName(photoURL string) string {
+	sum := sha256.Sum256([]byte(photoURL))
+	return hex.EncodeToString(sum[:])
 }
 
 // contains returns true if the given slice contains the given value
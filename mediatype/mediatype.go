@@ -0,0 +1,203 @@
+// Package mediatype infers a file extension for a downloadable URL. It
+// prefers a HEAD response's Content-Type, falls back to sniffing the first
+// bytes of a GET response when HEAD is unavailable or unhelpful, and falls
+// back again to the URL's own suffix as a last resort.
+package mediatype
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/edosssa/home-vision-project/xfer"
+)
+
+// sniffLen is how many leading bytes of a GET response are inspected when
+// HEAD doesn't yield a usable Content-Type.
+const sniffLen = 512
+
+// extByMIME maps known image MIME types (params already stripped) to their
+// file extension.
+var extByMIME = map[string]string{
+	"image/jpeg":    "jpg",
+	"image/png":     "png",
+	"image/gif":     "gif",
+	"image/svg+xml": "svg",
+	"image/webp":    "webp",
+	"image/avif":    "avif",
+	"image/heic":    "heic",
+}
+
+// Result is the outcome of Detect.
+type Result struct {
+	// Ext is the detected file extension, without a leading dot. It's
+	// never empty: Detect always falls back to the URL suffix, and then to
+	// "bin", as a last resort.
+	Ext string
+
+	// Body, if non-nil, is an already-open GET response body whose
+	// leading bytes were consumed to sniff Ext. Callers that go on to
+	// download the resource should read from Body instead of issuing
+	// their own GET, so the sniffed bytes aren't requested twice. It's
+	// nil when detection only needed a HEAD request.
+	Body io.ReadCloser
+}
+
+// Detect determines the file extension for rawURL: first via a HEAD
+// request's Content-Type, then, if that's absent or not a recognized image
+// type, by sniffing the first sniffLen bytes of a GET response, and finally
+// by the URL's own path suffix.
+func Detect(ctx context.Context, client *http.Client, rawURL string) (Result, error) {
+	if ext, ok := fromHead(ctx, client, rawURL); ok {
+		return Result{Ext: ext}, nil
+	}
+
+	return fromGet(ctx, client, rawURL)
+}
+
+// fromHead issues a HEAD request and maps its Content-Type to an extension.
+func fromHead(ctx context.Context, client *http.Client, rawURL string) (string, bool) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, rawURL, nil)
+	if err != nil {
+		return "", false
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", false
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", false
+	}
+
+	return FromContentType(resp.Header.Get("Content-Type"))
+}
+
+// fromGet issues a GET request, sniffs its first bytes for an extension,
+// and falls back to the URL suffix. The returned Result's Body must be
+// consumed (or closed) by the caller.
+func fromGet(ctx context.Context, client *http.Client, rawURL string) (Result, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return Result{}, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return Result{}, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return Result{}, &xfer.HTTPStatusError{StatusCode: resp.StatusCode}
+	}
+
+	ext, sniffed, err := Sniff(resp.Body)
+	if err != nil {
+		resp.Body.Close()
+		return Result{}, err
+	}
+	if ext == "" {
+		ext = FromURL(rawURL)
+	}
+	if ext == "" {
+		ext = "bin"
+	}
+
+	body := struct {
+		io.Reader
+		io.Closer
+	}{
+		Reader: io.MultiReader(bytes.NewReader(sniffed), resp.Body),
+		Closer: resp.Body,
+	}
+
+	return Result{Ext: ext, Body: body}, nil
+}
+
+// FromContentType parses a Content-Type header value, strips any
+// parameters (e.g. "; charset=utf-8"), and maps it to a known extension.
+func FromContentType(header string) (string, bool) {
+	if header == "" {
+		return "", false
+	}
+
+	mt, _, err := mime.ParseMediaType(header)
+	if err != nil {
+		// A bare type with no parameters still trips up strict parsing on
+		// some malformed headers; fall back to the raw value.
+		mt = strings.TrimSpace(header)
+	}
+
+	ext, ok := extByMIME[mt]
+	return ext, ok
+}
+
+// Sniff reads up to sniffLen bytes from r to determine a file extension,
+// using http.DetectContentType and a small custom sniffer for container
+// formats the stdlib misses (WebP, AVIF, HEIC). It returns the bytes it
+// consumed from r regardless of whether an extension was identified, so the
+// caller can still read the rest of the stream from the start.
+func Sniff(r io.Reader) (ext string, sniffed []byte, err error) {
+	buf := make([]byte, sniffLen)
+	n, err := io.ReadFull(r, buf)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", buf[:n], err
+	}
+	sniffed = buf[:n]
+
+	if ext, ok := extByMIME[http.DetectContentType(sniffed)]; ok {
+		return ext, sniffed, nil
+	}
+	return sniffContainer(sniffed), sniffed, nil
+}
+
+// sniffContainer recognizes a handful of container-based image formats that
+// http.DetectContentType doesn't know about.
+func sniffContainer(b []byte) string {
+	if len(b) >= 12 && string(b[0:4]) == "RIFF" && string(b[8:12]) == "WEBP" {
+		return "webp"
+	}
+
+	if len(b) >= 12 && string(b[4:8]) == "ftyp" {
+		switch string(b[8:12]) {
+		case "avif", "avis":
+			return "avif"
+		case "heic", "heix", "mif1", "msf1":
+			return "heic"
+		}
+	}
+
+	return ""
+}
+
+// FromURL falls back to the extension implied by rawURL's path suffix, if
+// any.
+func FromURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimPrefix(path.Ext(u.Path), ".")
+}
+
+// SanitizeFilename strips path separators and control characters from s so
+// it's safe to interpolate into a filename, e.g. a Homeowner or Address
+// field pulled from an API response.
+func SanitizeFilename(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '/' || r == '\\':
+		case r < 0x20 || r == 0x7f:
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,118 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+const defaultUploadConcurrency = 5
+
+// s3Sink uploads every entry to a bucket/prefix using the AWS SDK's
+// managed uploader, which handles multipart uploads for large files.
+type s3Sink struct {
+	client   *s3.Client
+	uploader *manager.Uploader
+	bucket   string
+	prefix   string
+}
+
+// NewS3Sink builds a Sink targeting the bucket and prefix in u (the path
+// component of an s3://bucket/prefix URL), using u's "region" and
+// "concurrency" query parameters if present.
+func NewS3Sink(u *url.URL) (*s3Sink, error) {
+	region := u.Query().Get("region")
+
+	cfg, err := awsconfig.LoadDefaultConfig(context.Background(), awsconfig.WithRegion(region))
+	if err != nil {
+		return nil, fmt.Errorf("Error loading AWS config: %s", err)
+	}
+
+	client := s3.NewFromConfig(cfg)
+
+	concurrency := defaultUploadConcurrency
+	if v := u.Query().Get("concurrency"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			concurrency = n
+		}
+	}
+
+	return &s3Sink{
+		client:   client,
+		uploader: manager.NewUploader(client, func(u *manager.Uploader) { u.Concurrency = concurrency }),
+		bucket:   u.Host,
+		prefix:   strings.TrimPrefix(u.Path, "/"),
+	}, nil
+}
+
+func (s *s3Sink) key(name string) string {
+	if s.prefix == "" {
+		return name
+	}
+	return strings.TrimSuffix(s.prefix, "/") + "/" + name
+}
+
+// Create returns a writer whose contents are streamed to S3 as they're
+// written; the actual PutObject/multipart upload happens in a background
+// goroutine reading from the other end of an io.Pipe.
+func (s *s3Sink) Create(name string, size int64) (io.WriteCloser, error) {
+	pr, pw := io.Pipe()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := s.uploader.Upload(context.Background(), &s3.PutObjectInput{
+			Bucket: aws.String(s.bucket),
+			Key:    aws.String(s.key(name)),
+			Body:   pr,
+		})
+		if err != nil {
+			// The uploader's internal read loop stops calling Read on pr
+			// once it gives up on a failed part, so without this a caller
+			// still writing to pw would block on the pipe forever.
+			// CloseWithError unblocks any pending or future Write with err
+			// instead.
+			pr.CloseWithError(err)
+		}
+		done <- err
+	}()
+
+	return &s3EntryWriter{pw: pw, done: done}, nil
+}
+
+func (s *s3Sink) Exists(name string) (bool, error) {
+	_, err := s.client.HeadObject(context.Background(), &s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.key(name)),
+	})
+	if err != nil {
+		// The SDK doesn't expose a typed "not found" we can rely on here
+		// without the smithy error helpers, so treat any HeadObject
+		// failure as "doesn't exist".
+		return false, nil
+	}
+	return true, nil
+}
+
+type s3EntryWriter struct {
+	pw   *io.PipeWriter
+	done chan error
+}
+
+func (w *s3EntryWriter) Write(p []byte) (int, error) {
+	return w.pw.Write(p)
+}
+
+func (w *s3EntryWriter) Close() error {
+	if err := w.pw.Close(); err != nil {
+		return err
+	}
+	return <-w.done
+}
@@ -0,0 +1,108 @@
+// Package storage abstracts where downloaded files end up: a local
+// directory, a single tar archive, or an S3 bucket/prefix, selected at
+// runtime via an --output=scheme://... flag.
+package storage
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+)
+
+// ResumeSidecarSuffix is appended to an entry's path to name the JSON
+// sidecar the downloader's ranged mode uses to resume an interrupted
+// download. It's exported so a RandomAccessSink's Exists can tell a
+// completed entry apart from one left mid-download by a prior run.
+const ResumeSidecarSuffix = ".part.json"
+
+// Sink is a destination files are written to.
+type Sink interface {
+	// Create opens name for writing. size is the file's expected total
+	// size, or 0 or less (e.g. an unknown Content-Length of -1) if unknown;
+	// sinks that must know an entry's size up front (e.g. tar) buffer the
+	// write when size isn't positive.
+	Create(name string, size int64) (io.WriteCloser, error)
+
+	// Exists reports whether name has already been fully written to this
+	// sink. It backs --skip-existing.
+	Exists(name string) (bool, error)
+}
+
+// Closer is implemented by sinks that must finalize state once every
+// download has completed, e.g. a tar sink writing its end-of-archive
+// trailer. Sinks with nothing to finalize (fs, S3) simply don't implement
+// it; callers should type-assert for it rather than assume it's present.
+type Closer interface {
+	Close() error
+}
+
+// SkipExistingIncompatible is implemented by sinks whose Exists can't
+// support an idempotent --skip-existing rerun, so callers should refuse
+// that combination instead of silently accepting it. A tar sink is the
+// motivating case: NewTarSink truncates the archive on open and Exists
+// only reflects entries written during the current process, so
+// --skip-existing against it would re-download and rewrite every entry on
+// every run while reporting success.
+type SkipExistingIncompatible interface {
+	// SkipExistingIncompatibleReason explains why --skip-existing can't
+	// work against this sink, for use in an error message.
+	SkipExistingIncompatibleReason() string
+}
+
+// WriterAtCloser is a random-access destination for a single entry.
+type WriterAtCloser interface {
+	io.WriterAt
+	io.Closer
+}
+
+// RandomAccessSink is implemented by sinks that can be written to at
+// arbitrary offsets. The downloader's multi-connection ranged download mode
+// requires this; sinks that only support sequential writes (tar, S3) fall
+// back to a single-stream download.
+type RandomAccessSink interface {
+	Sink
+
+	// OpenAt opens name for writing at arbitrary offsets, preallocating
+	// size bytes.
+	OpenAt(name string, size int64) (WriterAtCloser, error)
+
+	// Path returns the local filesystem path backing name, used to persist
+	// a download's resume sidecar alongside it.
+	Path(name string) string
+
+	// ReadFile, WriteFile, and RemoveFile give the downloader access to
+	// this sink's own filesystem for the resume sidecar it persists
+	// alongside path (as returned by Path). Routing the sidecar through
+	// these instead of the os package directly means ranged downloads work
+	// against non-OS-backed sinks too (e.g. an in-memory afero.Fs in
+	// tests).
+	ReadFile(path string) ([]byte, error)
+	WriteFile(path string, data []byte) error
+	RemoveFile(path string) error
+}
+
+// Open parses an --output value and returns the corresponding Sink.
+// Supported schemes are fs://, tar://, and s3://.
+func Open(output string) (Sink, error) {
+	scheme, rest, ok := strings.Cut(output, "://")
+	if !ok {
+		// No scheme given; treat the whole value as an fs path.
+		return NewFSSink(output)
+	}
+
+	switch scheme {
+	case "fs":
+		return NewFSSink(rest)
+	case "tar":
+		return NewTarSink(rest)
+	case "s3":
+		u, err := url.Parse(output)
+		if err != nil {
+			return nil, fmt.Errorf("Error parsing output %q: %s", output, err)
+		}
+		return NewS3Sink(u)
+	default:
+		return nil, fmt.Errorf("Error: unknown output scheme %q", scheme)
+	}
+}
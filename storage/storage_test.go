@@ -0,0 +1,107 @@
+package storage_test
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/edosssa/home-vision-project/downloader"
+	"github.com/edosssa/home-vision-project/storage"
+	"github.com/spf13/afero"
+)
+
+// TestFSSink_Integration exercises the full path from an HTTP download down
+// to the filesystem sink, using an in-memory afero.Fs so the test doesn't
+// touch disk.
+func TestFSSink_Integration(t *testing.T) {
+	content := bytes.Repeat([]byte("house"), 2048)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "img", time.Time{}, bytes.NewReader(content))
+	}))
+	defer srv.Close()
+
+	fs := afero.NewMemMapFs()
+	sink, err := storage.NewFSSinkFS(fs, "/out")
+	if err != nil {
+		t.Fatalf("NewFSSinkFS: %s", err)
+	}
+
+	if err := downloader.Download(context.Background(), srv.URL, sink, "house.bin", downloader.Options{
+		ConnectionsPerFile: 4,
+		MinChunkedSize:     1,
+	}); err != nil {
+		t.Fatalf("Download: %s", err)
+	}
+
+	got, err := afero.ReadFile(fs, sink.Path("house.bin"))
+	if err != nil {
+		t.Fatalf("ReadFile: %s", err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Fatalf("downloaded content mismatch: got %d bytes, want %d", len(got), len(content))
+	}
+
+	exists, err := sink.Exists("house.bin")
+	if err != nil {
+		t.Fatalf("Exists: %s", err)
+	}
+	if !exists {
+		t.Fatal("Exists returned false for a completed download")
+	}
+}
+
+// TestFSSink_ExistsIgnoresInterruptedDownload asserts that a destination
+// file left behind by an interrupted ranged download, with its resume
+// sidecar still present, doesn't count as existing for --skip-existing.
+func TestFSSink_ExistsIgnoresInterruptedDownload(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	sink, err := storage.NewFSSinkFS(fs, "/out")
+	if err != nil {
+		t.Fatalf("NewFSSinkFS: %s", err)
+	}
+
+	w, err := sink.OpenAt("house.bin", 1024)
+	if err != nil {
+		t.Fatalf("OpenAt: %s", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %s", err)
+	}
+
+	if err := afero.WriteFile(fs, sink.Path("house.bin")+storage.ResumeSidecarSuffix, []byte(`{}`), 0644); err != nil {
+		t.Fatalf("WriteFile: %s", err)
+	}
+
+	exists, err := sink.Exists("house.bin")
+	if err != nil {
+		t.Fatalf("Exists: %s", err)
+	}
+	if exists {
+		t.Fatal("Exists returned true for a download with an unfinished resume sidecar")
+	}
+}
+
+// TestTarSink_SkipExistingIncompatible asserts that a tar sink flags itself
+// as incompatible with --skip-existing, since NewTarSink truncates the
+// archive on open and Exists only sees entries written by the current
+// process.
+func TestTarSink_SkipExistingIncompatible(t *testing.T) {
+	sink, err := storage.NewTarSink(filepath.Join(t.TempDir(), "houses.tar"))
+	if err != nil {
+		t.Fatalf("NewTarSink: %s", err)
+	}
+	defer sink.Close()
+
+	incompatible, ok := interface{}(sink).(storage.SkipExistingIncompatible)
+	if !ok {
+		t.Fatal("tarSink does not implement storage.SkipExistingIncompatible")
+	}
+	if incompatible.SkipExistingIncompatibleReason() == "" {
+		t.Fatal("SkipExistingIncompatibleReason returned an empty reason")
+	}
+}
@@ -0,0 +1,111 @@
+package storage
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// tarSink streams every entry into a single tar archive. Entries are
+// serialized under a mutex: the mutex is held from the moment an entry's
+// header is written until its Close, so a second Create blocks until the
+// first entry finishes.
+type tarSink struct {
+	mu      sync.Mutex
+	f       *os.File
+	tw      *tar.Writer
+	written map[string]bool
+}
+
+// NewTarSink builds a Sink that appends every entry to a single tar archive
+// at path, creating or truncating it.
+func NewTarSink(path string) (*tarSink, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &tarSink{
+		f:       f,
+		tw:      tar.NewWriter(f),
+		written: make(map[string]bool),
+	}, nil
+}
+
+// Create returns a writer for a new tar entry. When size is known, the
+// header is written immediately and writes stream straight into the
+// archive; when size is 0 or less (unknown), the entry is buffered in memory so a
+// correct header can be written once the final size is known.
+func (s *tarSink) Create(name string, size int64) (io.WriteCloser, error) {
+	s.mu.Lock()
+
+	if size > 0 {
+		if err := s.tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: size}); err != nil {
+			s.mu.Unlock()
+			return nil, err
+		}
+		return &tarEntryWriter{sink: s, name: name}, nil
+	}
+
+	return &tarEntryWriter{sink: s, name: name, buf: &bytes.Buffer{}}, nil
+}
+
+func (s *tarSink) Exists(name string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.written[name], nil
+}
+
+// SkipExistingIncompatibleReason implements SkipExistingIncompatible: every
+// run truncates the archive and written only tracks entries written during
+// the current process, so Exists can never see a previous run's output.
+func (s *tarSink) SkipExistingIncompatibleReason() string {
+	return "--skip-existing has no effect against --output=tar://..., since each run truncates the archive"
+}
+
+// Close finalizes the archive by writing its end-of-archive trailer. It's
+// not part of the Sink interface, since fs and S3 sinks have nothing to
+// finalize, but it satisfies storage.Closer; callers should type-assert for
+// that once all downloads have completed.
+func (s *tarSink) Close() error {
+	if err := s.tw.Close(); err != nil {
+		s.f.Close()
+		return err
+	}
+	return s.f.Close()
+}
+
+// tarEntryWriter is the io.WriteCloser handed back by tarSink.Create. When
+// buf is set, writes accumulate in memory until Close, at which point the
+// header and buffered content are written together.
+type tarEntryWriter struct {
+	sink *tarSink
+	name string
+	buf  *bytes.Buffer
+}
+
+func (w *tarEntryWriter) Write(p []byte) (int, error) {
+	if w.buf != nil {
+		return w.buf.Write(p)
+	}
+	return w.sink.tw.Write(p)
+}
+
+func (w *tarEntryWriter) Close() error {
+	defer func() {
+		w.sink.written[w.name] = true
+		w.sink.mu.Unlock()
+	}()
+
+	if w.buf == nil {
+		return nil
+	}
+
+	if err := w.sink.tw.WriteHeader(&tar.Header{Name: w.name, Mode: 0644, Size: int64(w.buf.Len())}); err != nil {
+		return fmt.Errorf("Error writing tar header for %s: %s", w.name, err)
+	}
+	_, err := w.sink.tw.Write(w.buf.Bytes())
+	return err
+}
@@ -0,0 +1,62 @@
+package ui
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// plainReporter is the non-TTY ProgressReporter: it prints one line per
+// completed download and otherwise stays quiet, so CI logs don't fill up
+// with cursor-repositioning bar redraws.
+type plainReporter struct {
+	mu        sync.Mutex
+	completed int
+}
+
+func newPlainReporter() *plainReporter {
+	return &plainReporter{}
+}
+
+func (r *plainReporter) Page(pageNumber, total int) PageProgress {
+	return &plainPage{reporter: r, pageNumber: pageNumber, total: total}
+}
+
+func (r *plainReporter) Stop() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	fmt.Fprintf(os.Stderr, "Downloaded %d images\n", r.completed)
+}
+
+type plainPage struct {
+	reporter   *plainReporter
+	pageNumber int
+	total      int
+}
+
+func (p *plainPage) File(name string, size int64) FileProgress {
+	return &plainFile{page: p, name: name}
+}
+
+type plainFile struct {
+	page *plainPage
+	name string
+}
+
+// Add is a no-op in the plain reporter: byte-level progress is too noisy
+// for a line-oriented log.
+func (f *plainFile) Add(n int64) {}
+
+func (f *plainFile) Done(err error) {
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "page %d: failed %s: %s\n", f.page.pageNumber, f.name, err)
+		return
+	}
+
+	f.page.reporter.mu.Lock()
+	f.page.reporter.completed++
+	completed := f.page.reporter.completed
+	f.page.reporter.mu.Unlock()
+
+	fmt.Fprintf(os.Stderr, "page %d: downloaded %s (%d total)\n", f.page.pageNumber, f.name, completed)
+}
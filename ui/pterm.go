@@ -0,0 +1,104 @@
+package ui
+
+import (
+	"strconv"
+	"sync"
+
+	"github.com/pterm/pterm"
+)
+
+// ptermReporter renders a pool of bars via pterm's multi-printer: one
+// aggregate "Total" bar, one bar per page, and a transient bar per
+// in-flight file download that disappears once the file completes.
+type ptermReporter struct {
+	multi *pterm.MultiPrinter
+	total *pterm.ProgressbarPrinter
+
+	mu    sync.Mutex
+	pages map[int]*pterm.ProgressbarPrinter
+}
+
+func newPtermReporter() *ptermReporter {
+	multi, _ := pterm.DefaultMultiPrinter.Start()
+
+	total, _ := pterm.DefaultProgressbar.
+		WithWriter(multi.NewWriter()).
+		WithTitle("Total").
+		Start()
+
+	return &ptermReporter{
+		multi: multi,
+		total: total,
+		pages: make(map[int]*pterm.ProgressbarPrinter),
+	}
+}
+
+func (r *ptermReporter) Page(pageNumber, total int) PageProgress {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.total.Total += total
+
+	bar, ok := r.pages[pageNumber]
+	if !ok {
+		bar, _ = pterm.DefaultProgressbar.
+			WithWriter(r.multi.NewWriter()).
+			WithTotal(total).
+			WithTitle(pageTitle(pageNumber)).
+			Start()
+		r.pages[pageNumber] = bar
+	}
+
+	return &ptermPage{reporter: r, bar: bar, pageNumber: pageNumber, total: total}
+}
+
+func (r *ptermReporter) Stop() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.total.Stop()
+	for _, bar := range r.pages {
+		bar.Stop()
+	}
+	r.multi.Stop()
+}
+
+type ptermPage struct {
+	reporter   *ptermReporter
+	bar        *pterm.ProgressbarPrinter
+	pageNumber int
+	total      int
+}
+
+func (p *ptermPage) File(name string, size int64) FileProgress {
+	fileBar, _ := pterm.DefaultProgressbar.
+		WithWriter(p.reporter.multi.NewWriter()).
+		WithTotal(int(size)).
+		WithTitle(name).
+		WithRemoveWhenDone(true).
+		Start()
+
+	return &ptermFile{page: p, bar: fileBar}
+}
+
+type ptermFile struct {
+	page *ptermPage
+	bar  *pterm.ProgressbarPrinter
+}
+
+func (f *ptermFile) Add(n int64) {
+	f.bar.Add(int(n))
+}
+
+func (f *ptermFile) Done(err error) {
+	f.bar.Stop()
+
+	f.page.reporter.mu.Lock()
+	f.page.bar.Increment()
+	f.page.reporter.total.Increment()
+	f.page.reporter.mu.Unlock()
+}
+
+func pageTitle(pageNumber int) string {
+	return "page " + strconv.Itoa(pageNumber)
+}
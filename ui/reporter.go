@@ -0,0 +1,52 @@
+// Package ui reports download progress to the user. It auto-selects between
+// a multi-bar terminal UI and a plain line-per-event logger, so CI and other
+// non-interactive runs don't get spammed with ANSI bar redraws.
+package ui
+
+import (
+	"os"
+
+	"golang.org/x/term"
+)
+
+// ProgressReporter is the top-level handle returned by New. One is created
+// per run and shared across all pages.
+type ProgressReporter interface {
+	// Page returns a reporter for a single page's bar, tracking `total`
+	// files.
+	Page(pageNumber, total int) PageProgress
+	// Stop finalizes every bar and prints a summary.
+	Stop()
+}
+
+// PageProgress reports progress for the files belonging to one page.
+type PageProgress interface {
+	// File returns a reporter for a single file's byte-level progress. size
+	// may be 0 if the file's size isn't known yet.
+	File(name string, size int64) FileProgress
+}
+
+// FileProgress reports byte-level progress for a single file download.
+type FileProgress interface {
+	// Add reports n additional bytes having been written.
+	Add(n int64)
+	// Done marks the file complete, successfully or not.
+	Done(err error)
+}
+
+// Options configures which ProgressReporter New builds.
+type Options struct {
+	// NoProgress forces the plain logger even when stderr is a terminal.
+	NoProgress bool
+}
+
+// New builds a ProgressReporter appropriate for the current environment: a
+// pterm multi-bar printer when stderr is a terminal and NoProgress isn't
+// set, otherwise a plain logger that prints one line per completed
+// download.
+func New(opts Options) ProgressReporter {
+	if opts.NoProgress || !term.IsTerminal(int(os.Stderr.Fd())) {
+		return newPlainReporter()
+	}
+	return newPtermReporter()
+}
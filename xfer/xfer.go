@@ -0,0 +1,224 @@
+// Package xfer provides a bounded-concurrency transfer manager with
+// in-flight deduplication and capped exponential-backoff retries. It
+// replaces ad-hoc "go func" fan-out paired with an infinite retry loop: jobs
+// are admitted onto a fixed-size queue, two transfers for the same key share
+// a single execution and notify every caller, and failures are retried with
+// jittered backoff instead of spinning tightly.
+package xfer
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	defaultMaxConcurrentDownloads = 5
+	defaultMaxConcurrentFetches   = 5
+
+	baseBackoff = 100 * time.Millisecond
+	maxBackoff  = 30 * time.Second
+	maxAttempts = 6
+)
+
+// XferFunc performs a single transfer attempt. It must honor ctx
+// cancellation.
+type XferFunc func(ctx context.Context) error
+
+// Watcher reports the outcome of a (possibly shared) in-flight transfer.
+type Watcher interface {
+	// Done is closed once the transfer completes, successfully or not.
+	Done() <-chan struct{}
+	// Err returns the transfer's result. It's only meaningful after Done is
+	// closed.
+	Err() error
+}
+
+// HTTPStatusError indicates a transfer failed because of an HTTP response
+// status. xfer uses it to distinguish permanent client errors, which aren't
+// worth retrying, from transient ones.
+type HTTPStatusError struct {
+	StatusCode int
+}
+
+func (e *HTTPStatusError) Error() string {
+	return "Error: unexpected HTTP status " + http.StatusText(e.StatusCode)
+}
+
+// Permanent reports whether the error should not be retried: any 4xx status
+// except 408 Request Timeout and 429 Too Many Requests, both of which are
+// expected to succeed on a later attempt.
+func (e *HTTPStatusError) Permanent() bool {
+	if e.StatusCode == 408 || e.StatusCode == 429 {
+		return false
+	}
+	return e.StatusCode >= 400 && e.StatusCode < 500
+}
+
+// isPermanent reports whether err should be treated as non-retryable.
+func isPermanent(err error) bool {
+	var statusErr *HTTPStatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.Permanent()
+	}
+	return false
+}
+
+type transfer struct {
+	done chan struct{}
+	err  error
+}
+
+type watcher struct {
+	t *transfer
+}
+
+func (w *watcher) Done() <-chan struct{} { return w.t.done }
+func (w *watcher) Err() error            { return w.t.err }
+
+// queue bounds concurrency for one class of transfer (e.g. downloads or page
+// fetches) and deduplicates in-flight work by key.
+type queue struct {
+	sem chan struct{}
+
+	mu       sync.Mutex
+	inflight map[string]*transfer
+}
+
+func newQueue(maxConcurrent int) *queue {
+	return &queue{
+		sem:      make(chan struct{}, maxConcurrent),
+		inflight: make(map[string]*transfer),
+	}
+}
+
+// transfer admits xferFunc under this queue's concurrency limit, or joins an
+// already-running transfer for the same key.
+func (q *queue) transfer(ctx context.Context, key string, xferFunc XferFunc) Watcher {
+	q.mu.Lock()
+	if t, ok := q.inflight[key]; ok {
+		q.mu.Unlock()
+		return &watcher{t}
+	}
+	t := &transfer{done: make(chan struct{})}
+	q.inflight[key] = t
+	q.mu.Unlock()
+
+	go func() {
+		defer func() {
+			q.mu.Lock()
+			delete(q.inflight, key)
+			q.mu.Unlock()
+			close(t.done)
+		}()
+
+		if ctx.Err() != nil {
+			t.err = ctx.Err()
+			return
+		}
+
+		select {
+		case q.sem <- struct{}{}:
+		case <-ctx.Done():
+			t.err = ctx.Err()
+			return
+		}
+		defer func() { <-q.sem }()
+
+		t.err = retryWithBackoff(ctx, xferFunc)
+	}()
+
+	return &watcher{t}
+}
+
+// retryWithBackoff runs xferFunc until it succeeds, returns a permanent
+// error, exhausts maxAttempts, or ctx is cancelled. Transient failures wait
+// with capped exponential backoff and jitter before the next attempt.
+func retryWithBackoff(ctx context.Context, xferFunc XferFunc) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	backoff := baseBackoff
+
+	var err error
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		err = xferFunc(ctx)
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if isPermanent(err) {
+			return err
+		}
+		if attempt == maxAttempts {
+			break
+		}
+
+		wait := backoff/2 + time.Duration(rand.Int63n(int64(backoff/2+1)))
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+	return err
+}
+
+// TransferManager submits page-fetch and image-download jobs onto two
+// independently bounded queues.
+type TransferManager struct {
+	downloads *queue
+	fetches   *queue
+}
+
+// Options configures a TransferManager's concurrency limits.
+type Options struct {
+	// MaxConcurrentDownloads bounds the number of image downloads in flight
+	// at once. Defaults to 5.
+	MaxConcurrentDownloads int
+	// MaxConcurrentFetches bounds the number of page-listing fetches in
+	// flight at once. Defaults to 5.
+	MaxConcurrentFetches int
+}
+
+func (o Options) withDefaults() Options {
+	if o.MaxConcurrentDownloads <= 0 {
+		o.MaxConcurrentDownloads = defaultMaxConcurrentDownloads
+	}
+	if o.MaxConcurrentFetches <= 0 {
+		o.MaxConcurrentFetches = defaultMaxConcurrentFetches
+	}
+	return o
+}
+
+// NewTransferManager builds a TransferManager with the given options.
+func NewTransferManager(opts Options) *TransferManager {
+	opts = opts.withDefaults()
+	return &TransferManager{
+		downloads: newQueue(opts.MaxConcurrentDownloads),
+		fetches:   newQueue(opts.MaxConcurrentFetches),
+	}
+}
+
+// Download submits an image-download job, deduplicated and bounded by
+// MaxConcurrentDownloads.
+func (m *TransferManager) Download(ctx context.Context, key string, xferFunc XferFunc) Watcher {
+	return m.downloads.transfer(ctx, key, xferFunc)
+}
+
+// Fetch submits a page-listing job, deduplicated and bounded by
+// MaxConcurrentFetches.
+func (m *TransferManager) Fetch(ctx context.Context, key string, xferFunc XferFunc) Watcher {
+	return m.fetches.transfer(ctx, key, xferFunc)
+}
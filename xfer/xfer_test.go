@@ -0,0 +1,148 @@
+package xfer
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTransferManager_DedupesInFlightKey(t *testing.T) {
+	mgr := NewTransferManager(Options{})
+
+	var calls int32
+	start := make(chan struct{})
+	xferFunc := func(ctx context.Context) error {
+		atomic.AddInt32(&calls, 1)
+		<-start
+		return nil
+	}
+
+	w1 := mgr.Download(context.Background(), "same-key", xferFunc)
+	w2 := mgr.Download(context.Background(), "same-key", xferFunc)
+	close(start)
+
+	<-w1.Done()
+	<-w2.Done()
+
+	if got := atomic.LoadInt32(&calls); got != 1 {
+		t.Fatalf("xferFunc called %d times for a duplicate key, want 1", got)
+	}
+	if w1.Err() != nil || w2.Err() != nil {
+		t.Fatalf("unexpected errors: %v, %v", w1.Err(), w2.Err())
+	}
+}
+
+func TestRetryWithBackoff_RetriesTransientErrors(t *testing.T) {
+	var attempts int32
+	err := retryWithBackoff(context.Background(), func(ctx context.Context) error {
+		if atomic.AddInt32(&attempts, 1) < 3 {
+			return &HTTPStatusError{StatusCode: 500}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("retryWithBackoff: %s", err)
+	}
+	if got := atomic.LoadInt32(&attempts); got != 3 {
+		t.Fatalf("attempts = %d, want 3", got)
+	}
+}
+
+func TestRetryWithBackoff_DoesNotRetryPermanentErrors(t *testing.T) {
+	var attempts int32
+	permanent := &HTTPStatusError{StatusCode: 404}
+
+	err := retryWithBackoff(context.Background(), func(ctx context.Context) error {
+		atomic.AddInt32(&attempts, 1)
+		return permanent
+	})
+	if err != permanent {
+		t.Fatalf("err = %v, want %v", err, permanent)
+	}
+	if got := atomic.LoadInt32(&attempts); got != 1 {
+		t.Fatalf("attempts = %d, want 1 for a permanent error", got)
+	}
+}
+
+func TestTransferManager_CancellationBeforeAdmission(t *testing.T) {
+	mgr := NewTransferManager(Options{MaxConcurrentDownloads: 1})
+
+	block := make(chan struct{})
+	defer close(block)
+
+	started := make(chan struct{})
+
+	// Occupy the only concurrency slot so the second job can't be admitted.
+	first := mgr.Download(context.Background(), "busy", func(ctx context.Context) error {
+		close(started)
+		<-block
+		return nil
+	})
+	<-started
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	var ran int32
+	second := mgr.Download(ctx, "queued", func(ctx context.Context) error {
+		atomic.AddInt32(&ran, 1)
+		return nil
+	})
+
+	<-second.Done()
+	if second.Err() != context.Canceled {
+		t.Fatalf("err = %v, want context.Canceled", second.Err())
+	}
+	if atomic.LoadInt32(&ran) != 0 {
+		t.Fatal("xferFunc ran despite its context being cancelled before admission")
+	}
+
+	block <- struct{}{}
+	<-first.Done()
+}
+
+func TestTransferManager_RespectsConcurrencyLimit(t *testing.T) {
+	const limit = 2
+	mgr := NewTransferManager(Options{MaxConcurrentDownloads: limit})
+
+	var (
+		mu          sync.Mutex
+		current     int
+		maxObserved int
+	)
+
+	var wg sync.WaitGroup
+	for i := 0; i < limit*3; i++ {
+		wg.Add(1)
+
+		// To avoid capturing the loop variable
+		i := i
+		go func() {
+			defer wg.Done()
+			w := mgr.Download(context.Background(), fmt.Sprintf("job-%d", i), func(ctx context.Context) error {
+				mu.Lock()
+				current++
+				if current > maxObserved {
+					maxObserved = current
+				}
+				mu.Unlock()
+
+				time.Sleep(10 * time.Millisecond)
+
+				mu.Lock()
+				current--
+				mu.Unlock()
+				return nil
+			})
+			<-w.Done()
+		}()
+	}
+	wg.Wait()
+
+	if maxObserved > limit {
+		t.Fatalf("observed %d concurrent transfers, want at most %d", maxObserved, limit)
+	}
+}